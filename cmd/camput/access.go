@@ -0,0 +1,112 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/schema"
+)
+
+// granteeList is a repeatable -grantee flag, collecting base64-encoded
+// x25519 public keys.
+type granteeList []string
+
+func (l *granteeList) String() string { return strings.Join(*l, ",") }
+
+func (l *granteeList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+type accessCmd struct {
+	password string
+	grantees granteeList
+}
+
+func init() {
+	RegisterCommand("access", func(flags *flag.FlagSet) CommandRunner {
+		cmd := new(accessCmd)
+		flags.StringVar(&cmd.password, "password", "", "Single shared password that unlocks the access blob. Mutually exclusive with -grantee.")
+		flags.Var(&cmd.grantees, "grantee", "Base64-encoded x25519 public key of a grantee who may unlock the access blob. May be repeated to grant access to several people; the resulting access blob requires the matching private key, not a password.")
+		return cmd
+	})
+}
+
+func (c *accessCmd) Describe() string {
+	return "Wrap an existing directory's entries in an access-controlled (ACT) blob, requiring either -password or one or more -grantee public keys to view."
+}
+
+func (c *accessCmd) Usage() {
+	fmt.Fprintf(stderr, "Usage: camput [globalopts] access [opts] <dir-blobref>\n")
+}
+
+func (c *accessCmd) RunCommand(up *Uploader, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("access takes exactly one argument: the directory blobref to protect")
+	}
+	if (c.password == "") == (len(c.grantees) == 0) {
+		return fmt.Errorf("access requires exactly one of -password or -grantee")
+	}
+	dirRef, ok := blob.Parse(args[0])
+	if !ok {
+		return fmt.Errorf("invalid blobref %q", args[0])
+	}
+
+	cl := up.Client
+	var accessRef, wrappedEntriesRef blob.Ref
+	var err error
+	if c.password != "" {
+		accessRef, wrappedEntriesRef, err = schema.WrapDirectoryAccess(cl, dirRef, c.password)
+	} else {
+		var pubKeys [][32]byte
+		pubKeys, err = decodeGranteePublicKeys(c.grantees)
+		if err == nil {
+			accessRef, wrappedEntriesRef, err = schema.WrapDirectoryAccessShared(cl, dirRef, pubKeys)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("access: %v", err)
+	}
+	newDirRef, err := schema.CloneDirectoryWithAccess(cl, dirRef, accessRef, wrappedEntriesRef)
+	if err != nil {
+		return fmt.Errorf("access: %v", err)
+	}
+	fmt.Printf("access=%s\ndir=%s\n", accessRef, newDirRef)
+	return nil
+}
+
+func decodeGranteePublicKeys(grantees []string) ([][32]byte, error) {
+	pubKeys := make([][32]byte, 0, len(grantees))
+	for _, g := range grantees {
+		raw, err := base64.StdEncoding.DecodeString(g)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -grantee %q: %v", g, err)
+		}
+		if len(raw) != 32 {
+			return nil, fmt.Errorf("invalid -grantee %q: want 32 bytes, got %d", g, len(raw))
+		}
+		var pubKey [32]byte
+		copy(pubKey[:], raw)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	return pubKeys, nil
+}
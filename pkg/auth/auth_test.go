@@ -0,0 +1,54 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	mode := &UserPass{Username: "alice", Password: "s3cret"}
+	called := false
+	handler := RequireAuth(mode, func(conn http.ResponseWriter, req *http.Request) {
+		called = true
+		conn.WriteHeader(http.StatusOK)
+	})
+
+	unauthed := httptest.NewRequest("GET", "/camli/foo", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, unauthed)
+	if called {
+		t.Error("handler was called for an unauthenticated request")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+
+	called = false
+	authed := httptest.NewRequest("GET", "/camli/foo", nil)
+	authed.SetBasicAuth("alice", "s3cret")
+	rec = httptest.NewRecorder()
+	handler(rec, authed)
+	if !called {
+		t.Error("handler was not called for an authenticated request")
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func init() {
+	RegisterAuthMode("cert", func(args []string) (AuthMode, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("Wrong cert auth string; needs to be \"cert:commonName1,commonName2,...\"")
+		}
+		return NewCert(args), nil
+	})
+}
+
+// Cert is an AuthMode that authenticates a request solely from the
+// Common Name of the client certificate presented during the TLS
+// handshake, against an allow-list. It requires the listener be
+// configured for tls.RequireAndVerifyClientCert (or equivalent); it
+// does no verification of its own beyond the allow-list check.
+type Cert struct {
+	allowed map[string]bool
+}
+
+// NewCert returns a Cert auth mode that allows any of the given
+// certificate Common Names.
+func NewCert(commonNames []string) *Cert {
+	allowed := make(map[string]bool, len(commonNames))
+	for _, cn := range commonNames {
+		allowed[cn] = true
+	}
+	return &Cert{allowed: allowed}
+}
+
+func (c *Cert) IsAuthorized(req *http.Request) bool {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	cn := req.TLS.PeerCertificates[0].Subject.CommonName
+	return c.allowed[cn]
+}
+
+func (c *Cert) Identify(req *http.Request) (Identity, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return Identity{}, fmt.Errorf("auth: no client certificate on request")
+	}
+	return Identity{User: req.TLS.PeerCertificates[0].Subject.CommonName, Method: "cert"}, nil
+}
+
+func (c *Cert) AddAuthHeader(req *http.Request) {
+	// Nothing to add: the client authenticates via its TLS certificate,
+	// negotiated by the transport, not an Authorization header.
+}
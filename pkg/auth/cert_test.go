@@ -0,0 +1,77 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// requestWithPeerCN builds a request carrying a synthetic TLS
+// connection state whose sole peer certificate has the given Common
+// Name, since there's no public constructor for tls.ConnectionState.
+func requestWithPeerCN(cn string) *http.Request {
+	req := httptest.NewRequest("GET", "/camli/foo", nil)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{
+			{Subject: pkix.Name{CommonName: cn}},
+		},
+	}
+	return req
+}
+
+func TestCertIsAuthorized(t *testing.T) {
+	c := NewCert([]string{"alice.example.com", "bob.example.com"})
+
+	if !c.IsAuthorized(requestWithPeerCN("alice.example.com")) {
+		t.Error("allow-listed Common Name rejected")
+	}
+	if c.IsAuthorized(requestWithPeerCN("mallory.example.com")) {
+		t.Error("non-allow-listed Common Name accepted")
+	}
+}
+
+func TestCertIsAuthorizedNoTLS(t *testing.T) {
+	c := NewCert([]string{"alice.example.com"})
+	req := httptest.NewRequest("GET", "/camli/foo", nil)
+	if c.IsAuthorized(req) {
+		t.Error("a plain HTTP request without TLS was authorized")
+	}
+}
+
+func TestCertIdentify(t *testing.T) {
+	c := NewCert([]string{"alice.example.com"})
+	id, err := c.Identify(requestWithPeerCN("alice.example.com"))
+	if err != nil {
+		t.Fatalf("Identify: %v", err)
+	}
+	if id.User != "alice.example.com" || id.Method != "cert" {
+		t.Errorf("Identify = %+v, want User=alice.example.com Method=cert", id)
+	}
+}
+
+func TestCertIdentifyNoTLS(t *testing.T) {
+	c := NewCert([]string{"alice.example.com"})
+	req := httptest.NewRequest("GET", "/camli/foo", nil)
+	if _, err := c.Identify(req); err == nil {
+		t.Error("Identify succeeded on a request with no client certificate")
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DigestTransport is an http.RoundTripper that authenticates against
+// an RFC 2617 Digest-protected server: the first request on a fresh
+// DigestTransport goes out unauthenticated, and on a 401 response
+// carrying a "WWW-Authenticate: Digest ..." challenge, it computes the
+// response hash from Username/Password and replays the request once
+// with the Authorization header set. The parsed challenge is cached,
+// so later requests authenticate preemptively (one round trip) until
+// the server rejects it again (e.g. because the nonce expired), at
+// which point the 401-and-retry dance repeats.
+type DigestTransport struct {
+	Username, Password string
+
+	// Transport is the underlying RoundTripper; http.DefaultTransport
+	// is used if nil.
+	Transport http.RoundTripper
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint64 // nonce count, incremented per request under this challenge
+}
+
+type digestChallenge struct {
+	realm, nonce, opaque, qop string
+}
+
+func parseDigestChallenge(header string) *digestChallenge {
+	if !strings.HasPrefix(header, "Digest ") {
+		return nil
+	}
+	params := parseDigestHeader(strings.TrimPrefix(header, "Digest "))
+	if params["nonce"] == "" {
+		return nil
+	}
+	return &digestChallenge{
+		realm:  params["realm"],
+		nonce:  params["nonce"],
+		opaque: params["opaque"],
+		qop:    params["qop"],
+	}
+}
+
+func (t *DigestTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+// authorize sets req's Authorization header from challenge and t's
+// credentials, using and incrementing t's nonce count.
+func (t *DigestTransport) authorize(req *http.Request, c *digestChallenge) {
+	t.mu.Lock()
+	t.nc++
+	nc := fmt.Sprintf("%08x", t.nc)
+	t.mu.Unlock()
+
+	cnonceBuf := make([]byte, 8)
+	rand.Read(cnonceBuf)
+	cnonce := hex.EncodeToString(cnonceBuf)
+
+	ha1 := md5hex(strings.Join([]string{t.Username, c.realm, t.Password}, ":"))
+	ha2 := md5hex(req.Method + ":" + req.URL.RequestURI())
+	var response string
+	if c.qop != "" {
+		response = md5hex(strings.Join([]string{ha1, c.nonce, nc, cnonce, c.qop, ha2}, ":"))
+	} else {
+		response = md5hex(ha1 + ":" + c.nonce + ":" + ha2)
+	}
+
+	header := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`,
+		t.Username, c.realm, c.nonce, req.URL.RequestURI(), response)
+	if c.qop != "" {
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce=%q`, c.qop, nc, cnonce)
+	}
+	if c.opaque != "" {
+		header += fmt.Sprintf(`, opaque=%q`, c.opaque)
+	}
+	req.Header.Set("Authorization", header)
+}
+
+// cloneRequest returns a shallow copy of req suitable for replaying:
+// its body, if any, is fully read and replaced with a fresh reader on
+// both the original and the copy, since http.Transport consumes req's
+// body on the first RoundTrip.
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	req2 := new(http.Request)
+	*req2 = *req
+	req2.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		req2.Header[k] = append([]string(nil), v...)
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req2.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return req2, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *DigestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	firstReq, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	cached := t.challenge
+	t.mu.Unlock()
+	if cached != nil {
+		t.authorize(firstReq, cached)
+	}
+
+	resp, err := t.transport().RoundTrip(firstReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := parseDigestChallenge(resp.Header.Get("WWW-Authenticate"))
+	if challenge == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	t.mu.Lock()
+	t.challenge = challenge
+	t.mu.Unlock()
+
+	retryReq, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	t.authorize(retryReq, challenge)
+	return t.transport().RoundTrip(retryReq)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func init() {
+	// groups wraps another registered mode, adding group membership to
+	// its resolved Identity: "groups:groupfile:delegateMode:delegateArgs...".
+	RegisterAuthMode("groups", func(args []string) (AuthMode, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("Wrong groups auth string; needs to be \"groups:groupfile:delegateMode:delegateArgs...\"")
+		}
+		delegate, err := FromConfig(strings.Join(args[1:], ":"))
+		if err != nil {
+			return nil, fmt.Errorf("groups: building delegate mode: %v", err)
+		}
+		return NewGroups(args[0], delegate), nil
+	})
+}
+
+// Groups is an AuthMode that delegates authentication to another mode
+// unchanged, but augments the Identity it resolves with group
+// membership looked up from a flat file. Without a wrapper like this,
+// no built-in AuthMode ever populates Identity.Groups, so an
+// ACLPolicy.Rule with Group set can never match a real request.
+type Groups struct {
+	Delegate  AuthMode
+	GroupFile string
+}
+
+// NewGroups returns a Groups AuthMode delegating authentication to
+// delegate and looking up group membership in groupFile, a file of
+// lines "user:group1,group2,...".
+func NewGroups(groupFile string, delegate AuthMode) *Groups {
+	return &Groups{Delegate: delegate, GroupFile: groupFile}
+}
+
+func (g *Groups) IsAuthorized(req *http.Request) bool { return g.Delegate.IsAuthorized(req) }
+
+func (g *Groups) AddAuthHeader(req *http.Request) { g.Delegate.AddAuthHeader(req) }
+
+func (g *Groups) SendUnauthorized(conn http.ResponseWriter, req *http.Request) {
+	SendUnauthorized(conn, req, g.Delegate)
+}
+
+func (g *Groups) Identify(req *http.Request) (Identity, error) {
+	id, err := g.Delegate.Identify(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	id.Groups = g.lookupGroups(id.User)
+	return id, nil
+}
+
+func (g *Groups) lookupGroups(user string) []string {
+	f, err := os.Open(g.GroupFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[0] == user {
+			return strings.Split(parts[1], ",")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestGroupsIdentify(t *testing.T) {
+	f, err := ioutil.TempFile("", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("alice:readers,admins\nbob:readers\n")
+	f.Close()
+
+	g := NewGroups(f.Name(), &UserPass{Username: "alice", Password: "s3cret"})
+
+	req := httptest.NewRequest("GET", "/camli/foo", nil)
+	req.SetBasicAuth("alice", "s3cret")
+	id, err := g.Identify(req)
+	if err != nil {
+		t.Fatalf("Identify: %v", err)
+	}
+	if id.User != "alice" {
+		t.Errorf("User = %q, want alice", id.User)
+	}
+	want := map[string]bool{"readers": true, "admins": true}
+	if len(id.Groups) != len(want) {
+		t.Fatalf("Groups = %v, want %v", id.Groups, want)
+	}
+	for _, group := range id.Groups {
+		if !want[group] {
+			t.Errorf("unexpected group %q in %v", group, id.Groups)
+		}
+	}
+}
+
+func TestGroupsIdentifyUnknownUser(t *testing.T) {
+	f, err := ioutil.TempFile("", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("alice:readers\n")
+	f.Close()
+
+	g := NewGroups(f.Name(), &UserPass{Username: "bob", Password: "hunter2"})
+	req := httptest.NewRequest("GET", "/camli/foo", nil)
+	req.SetBasicAuth("bob", "hunter2")
+	id, err := g.Identify(req)
+	if err != nil {
+		t.Fatalf("Identify: %v", err)
+	}
+	if len(id.Groups) != 0 {
+		t.Errorf("Groups = %v, want none for a user absent from the group file", id.Groups)
+	}
+}
+
+func TestGroupsIdentifyEndToEndWithACLPolicy(t *testing.T) {
+	f, err := ioutil.TempFile("", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("alice:readers\n")
+	f.Close()
+
+	g := NewGroups(f.Name(), &UserPass{Username: "alice", Password: "s3cret"})
+	policy := &ACLPolicy{Rules: []Rule{{Group: "readers", Ops: []string{"read"}}}}
+
+	req := httptest.NewRequest("GET", "/camli/foo", nil)
+	req.SetBasicAuth("alice", "s3cret")
+	id, err := g.Identify(req)
+	if err != nil {
+		t.Fatalf("Identify: %v", err)
+	}
+	ref := mustRef(t, "sha1-4444444444444444444444444444444444444444")
+	if !policy.AllowedOp(id, OpRead, ref) {
+		t.Error("a group rule didn't match an Identity populated via the groups wrapper")
+	}
+}
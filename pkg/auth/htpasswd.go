@@ -0,0 +1,122 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"camlistore.org/third_party/code.google.com/p/go.crypto/bcrypt"
+	"camlistore.org/third_party/code.google.com/p/go.crypto/md5crypt"
+)
+
+func init() {
+	RegisterAuthMode("htpasswd", func(args []string) (AuthMode, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("Wrong htpasswd auth string; needs to be \"htpasswd:path\"")
+		}
+		return NewHtpasswd(args[0])
+	})
+}
+
+// Htpasswd is an AuthMode backed by an Apache-style htpasswd file,
+// supporting bcrypt ("$2y$" / "$2a$"), APR's salted MD5 ("$apr1$",
+// shared format with the system crypt md5), and legacy SHA1 ("{SHA}")
+// password hashes.
+type Htpasswd struct {
+	Path string
+}
+
+// NewHtpasswd returns an Htpasswd auth mode reading credentials from
+// the htpasswd-formatted file at path on every check, so the file can
+// be edited (e.g. by the htpasswd tool) without restarting camlistored.
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	return &Htpasswd{Path: path}, nil
+}
+
+func (h *Htpasswd) lookup(user string) (hash string, ok bool) {
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[0] == user {
+			return parts[1], true
+		}
+	}
+	return "", false
+}
+
+// verify reports whether pass matches the given htpasswd hash, trying
+// bcrypt, SHA1, and MD5-crypt in turn based on the hash's prefix.
+func verifyHtpasswd(hash, pass string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		want := base64.StdEncoding.EncodeToString(sum[:])
+		return hash[len("{SHA}"):] == want
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		return md5crypt.Verify(hash, pass) == nil
+	default:
+		// Plaintext or crypt(3) DES, neither of which we support;
+		// refuse rather than silently treat as a password match.
+		return false
+	}
+}
+
+func (h *Htpasswd) IsAuthorized(req *http.Request) bool {
+	user, pass, err := basicAuth(req)
+	if err != nil {
+		return false
+	}
+	hash, ok := h.lookup(user)
+	if !ok {
+		return false
+	}
+	return verifyHtpasswd(hash, pass)
+}
+
+func (h *Htpasswd) Identify(req *http.Request) (Identity, error) {
+	user, _, err := basicAuth(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{User: user, Method: "htpasswd"}, nil
+}
+
+func (h *Htpasswd) AddAuthHeader(req *http.Request) {
+	// Htpasswd has no notion of a client-side password to send on
+	// behalf of the user; callers authenticate with SetBasicAuth
+	// themselves using credentials the user supplied out of band.
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import "testing"
+
+func TestVerifyHtpasswdBcrypt(t *testing.T) {
+	// A well-known bcrypt($2a$, cost 10) hash of "password".
+	const hash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+	if !verifyHtpasswd(hash, "password") {
+		t.Error("correct password rejected under bcrypt dispatch")
+	}
+	if verifyHtpasswd(hash, "wrong") {
+		t.Error("wrong password accepted under bcrypt dispatch")
+	}
+}
+
+func TestVerifyHtpasswdSHA(t *testing.T) {
+	// {SHA}base64(sha1("password")).
+	const hash = "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="
+	if !verifyHtpasswd(hash, "password") {
+		t.Error("correct password rejected under {SHA} dispatch")
+	}
+	if verifyHtpasswd(hash, "wrong") {
+		t.Error("wrong password accepted under {SHA} dispatch")
+	}
+}
+
+func TestVerifyHtpasswdMD5CryptDoesNotPanic(t *testing.T) {
+	for _, hash := range []string{
+		"$apr1$deadbeef$notarealhash......",
+		"$1$deadbeef$notarealhash......",
+	} {
+		if verifyHtpasswd(hash, "password") {
+			t.Errorf("garbage md5-crypt hash %q unexpectedly verified", hash)
+		}
+	}
+}
+
+func TestVerifyHtpasswdUnsupportedScheme(t *testing.T) {
+	// A bare plaintext or crypt(3) DES line (no recognized prefix)
+	// must never match, since we don't implement either.
+	if verifyHtpasswd("plaintextpassword", "plaintextpassword") {
+		t.Error("unsupported hash scheme was treated as a match")
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+// Identity is the structured principal behind a request, as resolved
+// by an AuthMode's Identify method.
+type Identity struct {
+	// User is the authenticated username, or "" for an AuthMode (like
+	// DevAuth or Cert with no allow-list grouping) that doesn't
+	// distinguish users.
+	User string
+	// Groups are the group names User belongs to, used by ACL rules
+	// that grant by group rather than by user.
+	Groups []string
+	// Method names which AuthMode authenticated the request (e.g.
+	// "userpass", "digest", "htpasswd", "cert"), for logging and for
+	// rules that care how, not just who.
+	Method string
+}
+
+// Operation is a kind of action a caller may want to perform against
+// the blob store, as checked by a Policy's AllowedOp.
+type Operation int
+
+const (
+	OpRead     Operation = iota // read/fetch a blob's contents
+	OpEnumerate                 // list/enumerate blobs
+	OpUpload                    // upload (put) a new blob
+	OpRemove                    // remove a blob
+	OpSign                      // sign a schema blob (JSON signing helper)
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OpRead:
+		return "read"
+	case OpEnumerate:
+		return "enumerate"
+	case OpUpload:
+		return "upload"
+	case OpRemove:
+		return "remove"
+	case OpSign:
+		return "sign"
+	default:
+		return "unknown"
+	}
+}
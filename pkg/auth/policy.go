@@ -0,0 +1,158 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/third_party/gopkg.in/yaml.v1"
+)
+
+// Policy decides whether an already-identified caller may perform op
+// against target.
+type Policy interface {
+	AllowedOp(id Identity, op Operation, target blob.Ref) bool
+}
+
+// AllowAllPolicy is the default Policy: any identified caller may
+// perform any operation. It preserves the behavior camlistored had
+// before per-operation ACLs existed, for deployments that don't need
+// more than "authenticated == trusted".
+type AllowAllPolicy struct{}
+
+func (AllowAllPolicy) AllowedOp(id Identity, op Operation, target blob.Ref) bool { return true }
+
+// Rule grants Ops on blobs whose ref starts with Prefix (empty Prefix
+// matches everything) to either User or Group (exactly one should be
+// set; User takes precedence if both are).
+type Rule struct {
+	User   string   `json:"user,omitempty" yaml:"user,omitempty"`
+	Group  string   `json:"group,omitempty" yaml:"group,omitempty"`
+	Ops    []string `json:"ops" yaml:"ops"`
+	Prefix string   `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+}
+
+// ACLPolicy is a Policy backed by an ordered list of Rules, loaded
+// from a YAML or JSON config file with LoadACL. The first matching
+// Rule wins; if none match, the operation is denied.
+type ACLPolicy struct {
+	Rules []Rule
+}
+
+// LoadACL reads an ACL policy from path. The format (YAML or JSON) is
+// chosen by the file's extension (.yaml, .yml, or .json).
+func LoadACL(path string) (*ACLPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading ACL file %s: %v", path, err)
+	}
+	var rules []Rule
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("auth: parsing ACL file %s: %v", path, err)
+		}
+	case ".json", "":
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("auth: parsing ACL file %s: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("auth: unrecognized ACL file extension %q", ext)
+	}
+	return &ACLPolicy{Rules: rules}, nil
+}
+
+func (p *ACLPolicy) AllowedOp(id Identity, op Operation, target blob.Ref) bool {
+	for _, r := range p.Rules {
+		if !ruleGrants(r, id) {
+			continue
+		}
+		if r.Prefix != "" && !strings.HasPrefix(target.String(), r.Prefix) {
+			continue
+		}
+		for _, opName := range r.Ops {
+			if opName == "*" || opName == op.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ruleGrants(r Rule, id Identity) bool {
+	if r.User != "" {
+		return r.User == id.User
+	}
+	if r.Group != "" {
+		for _, g := range id.Groups {
+			if g == r.Group {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+type contextKey int
+
+const identityContextKey contextKey = 0
+
+// FromContext returns the Identity stashed in ctx by RequirePolicy, if
+// any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityContextKey).(Identity)
+	return id, ok
+}
+
+// RequirePolicy wraps handler with a function that authenticates req
+// against mode, resolves its Identity, and checks policy.AllowedOp for
+// op against target(req) before calling handler with the Identity
+// available via FromContext(req.Context()). A nil policy defaults to
+// AllowAllPolicy, matching pre-ACL behavior.
+func RequirePolicy(mode AuthMode, policy Policy, op Operation, target func(req *http.Request) blob.Ref, handler func(conn http.ResponseWriter, req *http.Request)) func(conn http.ResponseWriter, req *http.Request) {
+	if policy == nil {
+		policy = AllowAllPolicy{}
+	}
+	return func(conn http.ResponseWriter, req *http.Request) {
+		if !mode.IsAuthorized(req) {
+			SendUnauthorized(conn, req, mode)
+			return
+		}
+		id, err := mode.Identify(req)
+		if err != nil {
+			SendUnauthorized(conn, req, mode)
+			return
+		}
+		var tgt blob.Ref
+		if target != nil {
+			tgt = target(req)
+		}
+		if !policy.AllowedOp(id, op, tgt) {
+			http.Error(conn, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler(conn, req.WithContext(context.WithValue(req.Context(), identityContextKey, id)))
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"testing"
+
+	"camlistore.org/pkg/blob"
+)
+
+func mustRef(t *testing.T, s string) blob.Ref {
+	ref, ok := blob.Parse(s)
+	if !ok {
+		t.Fatalf("blob.Parse(%q) failed", s)
+	}
+	return ref
+}
+
+func TestACLPolicyAllowedOp(t *testing.T) {
+	secretRef := mustRef(t, "sha1-1111111111111111111111111111111111111111")
+	publicRef := mustRef(t, "sha1-2222222222222222222222222222222222222222")
+
+	policy := &ACLPolicy{
+		Rules: []Rule{
+			{User: "alice", Ops: []string{"*"}},
+			{Group: "readers", Ops: []string{"read", "enumerate"}, Prefix: "sha1-22"},
+			{User: "bob", Ops: []string{"read"}, Prefix: "sha1-22"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		id   Identity
+		op   Operation
+		ref  blob.Ref
+		want bool
+	}{
+		{"user wildcard rule allows anything", Identity{User: "alice"}, OpRemove, secretRef, true},
+		{"group rule allows matching prefix", Identity{User: "carol", Groups: []string{"readers"}}, OpRead, publicRef, true},
+		{"group rule rejects non-matching prefix", Identity{User: "carol", Groups: []string{"readers"}}, OpRead, secretRef, false},
+		{"group rule rejects unlisted op", Identity{User: "carol", Groups: []string{"readers"}}, OpUpload, publicRef, false},
+		{"no matching rule denies", Identity{User: "mallory"}, OpRead, publicRef, false},
+		{"user rule rejects op not granted", Identity{User: "bob"}, OpUpload, publicRef, false},
+		{"user rule allows its op and prefix", Identity{User: "bob"}, OpRead, publicRef, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.AllowedOp(tt.id, tt.op, tt.ref); got != tt.want {
+				t.Errorf("AllowedOp(%+v, %v, %v) = %v, want %v", tt.id, tt.op, tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowAllPolicy(t *testing.T) {
+	ref := mustRef(t, "sha1-3333333333333333333333333333333333333333")
+	if !(AllowAllPolicy{}).AllowedOp(Identity{}, OpRemove, ref) {
+		t.Error("AllowAllPolicy denied an operation; it should allow everything")
+	}
+}
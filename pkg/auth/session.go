@@ -0,0 +1,271 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "camli-session"
+	csrfCookieName    = "camli-csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+
+	// defaultSessionTTL is how long an issued token stays valid before
+	// the browser must re-login.
+	defaultSessionTTL = 24 * time.Hour
+)
+
+// Session is an AuthMode that issues a signed, expiring bearer token
+// after a one-time login against Delegate (typically a UserPass,
+// Htpasswd, or Digest). Once a token has been issued, the web UI can
+// stop sending Delegate's credentials on every XHR: it presents either
+// an "Authorization: Bearer <token>" header or a camli-session cookie.
+type Session struct {
+	Delegate AuthMode
+	TTL      time.Duration
+
+	secret []byte
+}
+
+func init() {
+	// session wraps another registered mode: "session:userpass:joe:ponies".
+	RegisterAuthMode("session", func(args []string) (AuthMode, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("Wrong session auth string; needs to be \"session:delegateMode:delegateArgs...\"")
+		}
+		delegate, err := FromConfig(strings.Join(args, ":"))
+		if err != nil {
+			return nil, fmt.Errorf("session: building delegate mode: %v", err)
+		}
+		return NewSession(delegate, "")
+	})
+}
+
+// NewSession returns a Session AuthMode delegating logins to delegate.
+// secretPath names a file holding the HMAC secret; if empty or
+// missing, a new secret is generated and persisted there (so tokens
+// survive a camlistored restart), falling back to an in-memory-only
+// secret if secretPath can't be written.
+func NewSession(delegate AuthMode, secretPath string) (*Session, error) {
+	s := &Session{Delegate: delegate, TTL: defaultSessionTTL}
+	if secretPath != "" {
+		if data, err := ioutil.ReadFile(secretPath); err == nil && len(data) > 0 {
+			s.secret = data
+		}
+	}
+	if s.secret == nil {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		s.secret = secret
+		if secretPath != "" {
+			ioutil.WriteFile(secretPath, secret, 0600)
+		}
+	}
+	return s, nil
+}
+
+// token is the decoded, verified content of a session bearer token.
+type token struct {
+	user   string
+	issued int64
+	expiry int64
+	nonce  string
+}
+
+func (s *Session) sign(t token) string {
+	payload := strings.Join([]string{t.user, strconv.FormatInt(t.issued, 10), strconv.FormatInt(t.expiry, 10), t.nonce}, "|")
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	sig := hex.EncodeToString(mac.Sum(nil))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + sig
+}
+
+func (s *Session) verify(tok string) (token, bool) {
+	dot := strings.LastIndex(tok, ".")
+	if dot < 0 {
+		return token{}, false
+	}
+	encPayload, sig := tok[:dot], tok[dot+1:]
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return token{}, false
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return token{}, false
+	}
+	parts := strings.SplitN(string(payload), "|", 4)
+	if len(parts) != 4 {
+		return token{}, false
+	}
+	issued, err1 := strconv.ParseInt(parts[1], 10, 64)
+	expiry, err2 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return token{}, false
+	}
+	t := token{user: parts[0], issued: issued, expiry: expiry, nonce: parts[3]}
+	if time.Now().Unix() > t.expiry {
+		return token{}, false
+	}
+	return t, true
+}
+
+func (s *Session) newToken(user string) token {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	now := time.Now()
+	return token{
+		user:   user,
+		issued: now.Unix(),
+		expiry: now.Add(s.TTL).Unix(),
+		nonce:  hex.EncodeToString(nonce),
+	}
+}
+
+// tokenFromRequest extracts a bearer token from either the
+// Authorization header or the session cookie, reporting which (cookie
+// carriage matters: that's the one CSRF-checked).
+func tokenFromRequest(req *http.Request) (tok string, viaCookie bool) {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), false
+	}
+	if c, err := req.Cookie(sessionCookieName); err == nil {
+		return c.Value, true
+	}
+	return "", false
+}
+
+func (s *Session) IsAuthorized(req *http.Request) bool {
+	tok, viaCookie := tokenFromRequest(req)
+	if tok == "" {
+		return false
+	}
+	if _, ok := s.verify(tok); !ok {
+		return false
+	}
+	if viaCookie && req.Method != "GET" && req.Method != "HEAD" && req.Method != "OPTIONS" {
+		if !s.checkCSRF(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCSRF enforces the double-submit pattern: the csrf cookie value
+// must match the X-CSRF-Token header, proving the request originated
+// from JavaScript running on our own origin rather than a third-party
+// page riding the browser's cookie jar.
+func (s *Session) checkCSRF(req *http.Request) bool {
+	c, err := req.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return hmac.Equal([]byte(c.Value), []byte(req.Header.Get(csrfHeaderName)))
+}
+
+func (s *Session) Identify(req *http.Request) (Identity, error) {
+	tok, _ := tokenFromRequest(req)
+	t, ok := s.verify(tok)
+	if !ok {
+		return Identity{}, fmt.Errorf("auth: no valid session token on request")
+	}
+	return Identity{User: t.user, Method: "session"}, nil
+}
+
+func (s *Session) AddAuthHeader(req *http.Request) {
+	// Session has no client-side credential of its own to add; a
+	// client either already holds a bearer token (set explicitly by
+	// the caller after login) or falls back to Delegate.
+	s.Delegate.AddAuthHeader(req)
+}
+
+func (s *Session) SendUnauthorized(conn http.ResponseWriter, req *http.Request) {
+	SendUnauthorized(conn, req, s.Delegate)
+}
+
+// RegisterHandlers mounts s's login and logout endpoints at
+// prefix+"/auth/login" and prefix+"/auth/logout" on mux. Call this
+// alongside a camlistored's other handler registrations; without it,
+// ServeLogin and ServeLogout are never reached and clients have no way
+// to exchange Delegate credentials for a session token.
+func (s *Session) RegisterHandlers(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc(prefix+"/auth/login", s.ServeLogin)
+	mux.HandleFunc(prefix+"/auth/logout", s.ServeLogout)
+}
+
+// ServeLogin handles POST /auth/login: it authenticates req against
+// Delegate and, on success, sets the session and CSRF cookies and
+// also returns the bearer token as a JSON body, for clients (like the
+// web UI's XHR code) that prefer the Authorization header over
+// cookies.
+func (s *Session) ServeLogin(conn http.ResponseWriter, req *http.Request) {
+	if !s.Delegate.IsAuthorized(req) {
+		SendUnauthorized(conn, req, s.Delegate)
+		return
+	}
+	id, err := s.Delegate.Identify(req)
+	if err != nil {
+		http.Error(conn, "login failed", http.StatusUnauthorized)
+		return
+	}
+	t := s.newToken(id.User)
+	tokStr := s.sign(t)
+
+	http.SetCookie(conn, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    tokStr,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		Expires:  time.Unix(t.expiry, 0),
+	})
+	csrfTok := make([]byte, 16)
+	rand.Read(csrfTok)
+	http.SetCookie(conn, &http.Cookie{
+		Name:    csrfCookieName,
+		Value:   hex.EncodeToString(csrfTok),
+		Path:    "/",
+		Expires: time.Unix(t.expiry, 0),
+	})
+
+	conn.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(conn, `{"token":%q}`, tokStr)
+}
+
+// ServeLogout clears the session and CSRF cookies. It doesn't need to
+// invalidate the bearer token itself (tokens just expire on their
+// own), since Session keeps no server-side session store.
+func (s *Session) ServeLogout(conn http.ResponseWriter, req *http.Request) {
+	http.SetCookie(conn, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1})
+	http.SetCookie(conn, &http.Cookie{Name: csrfCookieName, Value: "", Path: "/", MaxAge: -1})
+	conn.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestSession(t *testing.T) *Session {
+	t.Helper()
+	s, err := NewSession(&UserPass{Username: "alice", Password: "s3cret"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSessionSignVerifyRoundTrip(t *testing.T) {
+	s := newTestSession(t)
+	tok := s.newToken("alice")
+	signed := s.sign(tok)
+
+	got, ok := s.verify(signed)
+	if !ok {
+		t.Fatal("verify rejected a freshly signed token")
+	}
+	if got.user != "alice" || got.issued != tok.issued || got.expiry != tok.expiry || got.nonce != tok.nonce {
+		t.Errorf("verify returned %+v, want %+v", got, tok)
+	}
+}
+
+func TestSessionVerifyRejectsTamperedPayload(t *testing.T) {
+	s := newTestSession(t)
+	signed := s.sign(s.newToken("alice"))
+
+	dot := -1
+	for i, c := range signed {
+		if c == '.' {
+			dot = i
+		}
+	}
+	if dot <= 0 {
+		t.Fatalf("signed token %q has no '.' separator", signed)
+	}
+	tampered := signed[:dot-1] + "X" + signed[dot:]
+	if _, ok := s.verify(tampered); ok {
+		t.Error("verify accepted a token whose payload was altered after signing")
+	}
+}
+
+func TestSessionVerifyRejectsForeignSecret(t *testing.T) {
+	s1 := newTestSession(t)
+	s2 := newTestSession(t) // independent random secret
+	signed := s1.sign(s1.newToken("alice"))
+	if _, ok := s2.verify(signed); ok {
+		t.Error("a token signed by one Session's secret verified under another's")
+	}
+}
+
+func TestSessionVerifyRejectsExpired(t *testing.T) {
+	s := newTestSession(t)
+	tok := token{user: "alice", issued: time.Now().Add(-2 * time.Hour).Unix(), expiry: time.Now().Add(-time.Hour).Unix(), nonce: "dead"}
+	signed := s.sign(tok)
+	if _, ok := s.verify(signed); ok {
+		t.Error("verify accepted an expired token")
+	}
+}
+
+func TestSessionVerifyRejectsMalformed(t *testing.T) {
+	s := newTestSession(t)
+	for _, bad := range []string{"", "no-dot-here", "not-base64!!!.deadbeef"} {
+		if _, ok := s.verify(bad); ok {
+			t.Errorf("verify(%q) unexpectedly succeeded", bad)
+		}
+	}
+}
+
+func TestSessionCheckCSRF(t *testing.T) {
+	s := newTestSession(t)
+
+	good := httptest.NewRequest("POST", "/camli/upload", nil)
+	good.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "tok123"})
+	good.Header.Set(csrfHeaderName, "tok123")
+	if !s.checkCSRF(good) {
+		t.Error("matching csrf cookie and header was rejected")
+	}
+
+	mismatched := httptest.NewRequest("POST", "/camli/upload", nil)
+	mismatched.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "tok123"})
+	mismatched.Header.Set(csrfHeaderName, "tok456")
+	if s.checkCSRF(mismatched) {
+		t.Error("mismatched csrf cookie and header was accepted")
+	}
+
+	missing := httptest.NewRequest("POST", "/camli/upload", nil)
+	missing.Header.Set(csrfHeaderName, "tok123")
+	if s.checkCSRF(missing) {
+		t.Error("missing csrf cookie was accepted")
+	}
+}
+
+func TestSessionIsAuthorized(t *testing.T) {
+	s := newTestSession(t)
+	tok := s.newToken("alice")
+	signed := s.sign(tok)
+
+	bearer := httptest.NewRequest("POST", "/camli/upload", nil)
+	bearer.Header.Set("Authorization", "Bearer "+signed)
+	if !s.IsAuthorized(bearer) {
+		t.Error("a valid bearer token on a POST (no CSRF needed off-cookie) was rejected")
+	}
+
+	cookieGet := httptest.NewRequest("GET", "/camli/ui", nil)
+	cookieGet.AddCookie(&http.Cookie{Name: sessionCookieName, Value: signed})
+	if !s.IsAuthorized(cookieGet) {
+		t.Error("a valid session cookie on a GET was rejected")
+	}
+
+	cookiePostNoCSRF := httptest.NewRequest("POST", "/camli/upload", nil)
+	cookiePostNoCSRF.AddCookie(&http.Cookie{Name: sessionCookieName, Value: signed})
+	if s.IsAuthorized(cookiePostNoCSRF) {
+		t.Error("a cookie-carried POST without a matching CSRF token was accepted")
+	}
+
+	cookiePostWithCSRF := httptest.NewRequest("POST", "/camli/upload", nil)
+	cookiePostWithCSRF.AddCookie(&http.Cookie{Name: sessionCookieName, Value: signed})
+	cookiePostWithCSRF.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "tok123"})
+	cookiePostWithCSRF.Header.Set(csrfHeaderName, "tok123")
+	if !s.IsAuthorized(cookiePostWithCSRF) {
+		t.Error("a cookie-carried POST with a matching CSRF token was rejected")
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrBatchUnsupported is returned by a BatchFetcher's FetchBatch when
+// the peer on the other end (e.g. the camlistored being talked to)
+// doesn't implement the batch endpoint. Callers that type-asserted
+// their way into a batch-capable Fetcher should treat this error as
+// "fall back to fetching refs one at a time", not as a hard failure,
+// since advertising the Go method doesn't guarantee the remote server
+// has the matching route.
+var ErrBatchUnsupported = errors.New("blob: server does not support batch fetch")
+
+// BatchResult is the outcome of fetching one of the refs passed to
+// FetchBatch.
+type BatchResult struct {
+	Ref      Ref
+	Size     int64
+	Contents io.ReadCloser // nil if Err is set
+	Err      error
+}
+
+// A BatchFetcher is an optional interface that a Fetcher may implement
+// to retrieve several blobs in one round-trip. Callers that hold a
+// plain Fetcher should type-assert for BatchFetcher before falling
+// back to issuing one Fetch per blob, e.g. when loading the many small
+// schema blobs referenced by a directory's static-set.
+type BatchFetcher interface {
+	// FetchBatch fetches refs and returns one BatchResult per ref, in
+	// the same order as refs. A failure to fetch an individual ref is
+	// reported via that BatchResult's Err field, not as the overall
+	// error return; the overall error is reserved for failures of the
+	// batch request itself (e.g. a transport error).
+	FetchBatch(refs []Ref) ([]BatchResult, error)
+}
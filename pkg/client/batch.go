@@ -0,0 +1,114 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"camlistore.org/pkg/blob"
+)
+
+// FetchBatch implements blob.BatchFetcher, fetching refs in a single
+// POST to the server's /camli/batch handler. The response is a
+// multipart/mixed body with one part per ref, each part's
+// Content-Disposition naming the ref it contains, so Client can load
+// hundreds of small directory-entry schema blobs in one TLS
+// round-trip instead of one per blob.
+func (c *Client) FetchBatch(refs []blob.Ref) ([]blob.BatchResult, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	names := make([]string, len(refs))
+	want := make(map[string]int, len(refs))
+	for i, r := range refs {
+		names[i] = r.String()
+		want[r.String()] = i
+	}
+	body, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.discoveryURL("/camli/batch"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.authMode.AddAuthHeader(req)
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client: batch fetch request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// Older camlistored, or one built without the batch handler.
+		// Let the caller (schema.DirReader) fall back to per-blob
+		// fetches rather than fail the whole listing.
+		return nil, blob.ErrBatchUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("client: batch fetch: status %v", resp.Status)
+	}
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("client: batch fetch: bad Content-Type: %v", err)
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+
+	results := make([]blob.BatchResult, len(refs))
+	seen := make(map[string]bool, len(refs))
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		_, cdParams, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			continue
+		}
+		name := cdParams["name"]
+		idx, ok := want[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			results[idx] = blob.BatchResult{Ref: refs[idx], Err: err}
+			continue
+		}
+		results[idx] = blob.BatchResult{
+			Ref:      refs[idx],
+			Size:     int64(len(data)),
+			Contents: ioutil.NopCloser(bytes.NewReader(data)),
+		}
+	}
+	for i, r := range refs {
+		if !seen[r.String()] {
+			results[i] = blob.BatchResult{Ref: r, Err: fmt.Errorf("client: batch fetch: server didn't return %s", r)}
+		}
+	}
+	return results, nil
+}
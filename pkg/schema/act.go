@@ -0,0 +1,419 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/third_party/code.google.com/p/go.crypto/curve25519"
+	"camlistore.org/third_party/code.google.com/p/go.crypto/scrypt"
+)
+
+// ErrAccessDenied is returned by StaticSet, Readdir, and NewDirReader
+// when a directory or static-set's entries are wrapped by an ACT
+// (Access Control Tree) access blob and no Credentials that unlock it
+// were supplied.
+var ErrAccessDenied = errors.New("schema: access denied; entries are access-controlled")
+
+const (
+	// granteePassword identifies an access blob unlockable by a single
+	// shared password.
+	granteePassword = "password"
+	// granteeShared identifies an access blob with a per-grantee
+	// lookup table, unlockable with an x25519 key pair.
+	granteeShared = "shared"
+)
+
+// Credentials authorizes decrypting an ACT-protected directory or
+// static-set. Exactly one of Password or PrivateKey is set, matching
+// the grantee mode the access blob was created with.
+type Credentials struct {
+	// Password unlocks an access blob created in single-password mode.
+	Password string
+
+	// PrivateKey is the grantee's x25519 private key, used to derive
+	// the shared secret for an access blob created in multi-grantee
+	// ("shared") mode.
+	PrivateKey *[32]byte
+}
+
+// accessSchema is the JSON shape of an "access" schema blob. It wraps
+// the session key used to decrypt a directory or static-set's Entries
+// blob.
+type accessSchema struct {
+	Type string `json:"camliType"` // "access"
+	Mode string `json:"grantMode"` // granteePassword or granteeShared
+
+	// Salt is the scrypt salt in password mode, base64-encoded.
+	Salt string `json:"salt,omitempty"`
+	// EncSessionKey is the session key, AES-CTR encrypted under
+	// scrypt(password, salt), base64-encoded. Password mode only.
+	EncSessionKey string `json:"encSessionKey,omitempty"`
+
+	// EphemeralPublicKey is the publisher's ephemeral x25519 public
+	// key, base64-encoded. Shared mode only.
+	EphemeralPublicKey string `json:"ephemeralPublicKey,omitempty"`
+	// Grantees is the shared-mode lookup table.
+	Grantees []granteeEntry `json:"grantees,omitempty"`
+}
+
+type granteeEntry struct {
+	// Lookup is base64(H(sharedSecret || salt)).
+	Lookup string `json:"lookup"`
+	// WrappedKey is base64(sessionKey XOR H(sharedSecret || "key")).
+	WrappedKey string `json:"wrappedKey"`
+	// Salt is this grantee's own salt, base64-encoded.
+	Salt string `json:"salt"`
+}
+
+func fetchAccessSchema(fetcher blob.SeekFetcher, accessRef blob.Ref) (*accessSchema, error) {
+	rsc, _, err := fetcher.Fetch(accessRef)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: fetching access blob %s: %v", accessRef, err)
+	}
+	defer rsc.Close()
+	as := new(accessSchema)
+	if err := json.NewDecoder(rsc).Decode(as); err != nil {
+		return nil, fmt.Errorf("schema/act: decoding access blob %s: %v", accessRef, err)
+	}
+	if as.Type != "access" {
+		return nil, fmt.Errorf("schema/act: expected \"access\" schema blob for %s, got %q", accessRef, as.Type)
+	}
+	return as, nil
+}
+
+// sessionKey derives the AES session key wrapped by as, using creds.
+// It returns ErrAccessDenied if creds don't unlock as.
+func (as *accessSchema) sessionKey(creds *Credentials) ([]byte, error) {
+	if creds == nil {
+		return nil, ErrAccessDenied
+	}
+	switch as.Mode {
+	case granteePassword:
+		return as.sessionKeyFromPassword(creds)
+	case granteeShared:
+		return as.sessionKeyFromSharedSecret(creds)
+	default:
+		return nil, fmt.Errorf("schema/act: unknown grantMode %q", as.Mode)
+	}
+}
+
+func (as *accessSchema) sessionKeyFromPassword(creds *Credentials) ([]byte, error) {
+	if creds.Password == "" {
+		return nil, ErrAccessDenied
+	}
+	salt, err := base64.StdEncoding.DecodeString(as.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: bad salt: %v", err)
+	}
+	enc, err := base64.StdEncoding.DecodeString(as.EncSessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: bad encSessionKey: %v", err)
+	}
+	derived, err := scrypt.Key([]byte(creds.Password), salt, 1<<14, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: scrypt: %v", err)
+	}
+	return ctrXcrypt(derived, enc), nil
+}
+
+func (as *accessSchema) sessionKeyFromSharedSecret(creds *Credentials) ([]byte, error) {
+	if creds.PrivateKey == nil {
+		return nil, ErrAccessDenied
+	}
+	ephemPub, err := base64.StdEncoding.DecodeString(as.EphemeralPublicKey)
+	if err != nil || len(ephemPub) != 32 {
+		return nil, fmt.Errorf("schema/act: bad ephemeralPublicKey: %v", err)
+	}
+	var ephemPubArr, shared [32]byte
+	copy(ephemPubArr[:], ephemPub)
+	curve25519.ScalarMult(&shared, creds.PrivateKey, &ephemPubArr)
+
+	for _, g := range as.Grantees {
+		salt, err := base64.StdEncoding.DecodeString(g.Salt)
+		if err != nil {
+			continue
+		}
+		lookup := hashConcat(shared[:], salt)
+		if base64.StdEncoding.EncodeToString(lookup) != g.Lookup {
+			continue
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(g.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("schema/act: bad wrappedKey: %v", err)
+		}
+		mask := hashConcat(shared[:], []byte("key"))
+		return xorBytes(wrapped, mask), nil
+	}
+	return nil, ErrAccessDenied
+}
+
+func hashConcat(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func xorBytes(a, b []byte) []byte {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// ctrXcrypt runs AES-CTR with a zero IV over data with key. It's used
+// both to wrap and unwrap the session key, since CTR is its own
+// inverse; key must be exactly 16, 24, or 32 bytes.
+func ctrXcrypt(key, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	iv := make([]byte, aes.BlockSize)
+	stream := cipher.NewCTR(block, iv)
+	out := make([]byte, len(data))
+	stream.XORKeyStream(out, data)
+	return out
+}
+
+// decryptEntries fetches the (encrypted) entriesRef blob, unwraps it
+// with sessionKey, and returns the plaintext, which is expected to be
+// a "static-set" schema blob. The first aes.BlockSize bytes of the
+// fetched blob are the CTR IV.
+func decryptEntries(fetcher blob.SeekFetcher, entriesRef blob.Ref, sessionKey []byte) ([]byte, error) {
+	rsc, _, err := fetcher.Fetch(entriesRef)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: fetching entries blob %s: %v", entriesRef, err)
+	}
+	defer rsc.Close()
+	raw, err := ioutil.ReadAll(rsc)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: reading entries blob %s: %v", entriesRef, err)
+	}
+	if len(raw) < aes.BlockSize {
+		return nil, fmt.Errorf("schema/act: entries blob %s too short to be encrypted", entriesRef)
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: bad session key: %v", err)
+	}
+	iv, ciphertext := raw[:aes.BlockSize], raw[aes.BlockSize:]
+	stream := cipher.NewCTR(block, iv)
+	plain := make([]byte, len(ciphertext))
+	stream.XORKeyStream(plain, ciphertext)
+	return plain, nil
+}
+
+// Uploader is the minimal capability WrapDirectoryAccess and
+// CloneDirectoryWithAccess need: fetch existing blobs, and upload new
+// ones, getting back their blobref. *client.Client satisfies it.
+type Uploader interface {
+	blob.SeekFetcher
+	Upload(contents []byte) (blob.Ref, error)
+}
+
+// fetchEntriesPlaintext reads dirRef's (unencrypted) entries blob, the
+// shared first step of wrapping a directory in either grantee mode.
+func fetchEntriesPlaintext(up Uploader, dirRef blob.Ref) (plain []byte, err error) {
+	ss := new(superset)
+	if err := ss.setFromBlobRef(up, dirRef); err != nil {
+		return nil, err
+	}
+	if !ss.Entries.Valid() {
+		return nil, fmt.Errorf("schema/act: %s has no entries to protect", dirRef)
+	}
+	rsc, _, err := up.Fetch(ss.Entries)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: fetching entries blob %s: %v", ss.Entries, err)
+	}
+	defer rsc.Close()
+	plain, err = ioutil.ReadAll(rsc)
+	if err != nil {
+		return nil, fmt.Errorf("schema/act: reading entries blob %s: %v", ss.Entries, err)
+	}
+	return plain, nil
+}
+
+// uploadWrappedEntries AES-CTR encrypts plain under a fresh random IV
+// with sessionKey and uploads it, prefixed with that IV as
+// decryptEntries expects.
+func uploadWrappedEntries(up Uploader, plain, sessionKey []byte) (blob.Ref, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return blob.Ref{}, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return blob.Ref{}, err
+	}
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plain)
+	ref, err := up.Upload(append(iv, ciphertext...))
+	if err != nil {
+		return blob.Ref{}, fmt.Errorf("schema/act: uploading wrapped entries: %v", err)
+	}
+	return ref, nil
+}
+
+// WrapDirectoryAccess encrypts dirRef's static-set entries blob under
+// a fresh session key, uploads the encrypted entries and a
+// single-password access blob that unlocks them, and returns both
+// refs. It does not itself modify dirRef; call
+// CloneDirectoryWithAccess to produce the updated directory blob that
+// points at them.
+func WrapDirectoryAccess(up Uploader, dirRef blob.Ref, password string) (accessRef, wrappedEntriesRef blob.Ref, err error) {
+	plain, err := fetchEntriesPlaintext(up, dirRef)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+	wrappedEntriesRef, err = uploadWrappedEntries(up, plain, sessionKey)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+	derived, err := scrypt.Key([]byte(password), salt, 1<<14, 8, 1, 32)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, fmt.Errorf("schema/act: scrypt: %v", err)
+	}
+	as := accessSchema{
+		Type:          "access",
+		Mode:          granteePassword,
+		Salt:          base64.StdEncoding.EncodeToString(salt),
+		EncSessionKey: base64.StdEncoding.EncodeToString(ctrXcrypt(derived, sessionKey)),
+	}
+	j, err := json.Marshal(as)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+	accessRef, err = up.Upload(j)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, fmt.Errorf("schema/act: uploading access blob: %v", err)
+	}
+	return accessRef, wrappedEntriesRef, nil
+}
+
+// WrapDirectoryAccessShared is like WrapDirectoryAccess, but grants
+// access to a set of grantees identified by their x25519 public keys,
+// rather than a single shared password. It generates a fresh ephemeral
+// x25519 key pair, derives a per-grantee shared secret via
+// curve25519.ScalarMult against each granteePublicKey, and populates
+// the access blob's Grantees lookup table so sessionKeyFromSharedSecret
+// can find and unwrap the session key with the matching private key.
+func WrapDirectoryAccessShared(up Uploader, dirRef blob.Ref, granteePublicKeys [][32]byte) (accessRef, wrappedEntriesRef blob.Ref, err error) {
+	if len(granteePublicKeys) == 0 {
+		return blob.Ref{}, blob.Ref{}, fmt.Errorf("schema/act: at least one grantee public key is required")
+	}
+	plain, err := fetchEntriesPlaintext(up, dirRef)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+	wrappedEntriesRef, err = uploadWrappedEntries(up, plain, sessionKey)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+
+	var ephemPriv, ephemPub [32]byte
+	if _, err := rand.Read(ephemPriv[:]); err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+	curve25519.ScalarBaseMult(&ephemPub, &ephemPriv)
+
+	grantees := make([]granteeEntry, 0, len(granteePublicKeys))
+	for _, granteePub := range granteePublicKeys {
+		var shared [32]byte
+		curve25519.ScalarMult(&shared, &ephemPriv, &granteePub)
+
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return blob.Ref{}, blob.Ref{}, err
+		}
+		lookup := hashConcat(shared[:], salt)
+		mask := hashConcat(shared[:], []byte("key"))
+		grantees = append(grantees, granteeEntry{
+			Lookup:     base64.StdEncoding.EncodeToString(lookup),
+			WrappedKey: base64.StdEncoding.EncodeToString(xorBytes(sessionKey, mask)),
+			Salt:       base64.StdEncoding.EncodeToString(salt),
+		})
+	}
+
+	as := accessSchema{
+		Type:               "access",
+		Mode:               granteeShared,
+		EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemPub[:]),
+		Grantees:           grantees,
+	}
+	j, err := json.Marshal(as)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, err
+	}
+	accessRef, err = up.Upload(j)
+	if err != nil {
+		return blob.Ref{}, blob.Ref{}, fmt.Errorf("schema/act: uploading access blob: %v", err)
+	}
+	return accessRef, wrappedEntriesRef, nil
+}
+
+// CloneDirectoryWithAccess uploads a new "directory" schema blob
+// identical to dirRef except that its entries and access fields point
+// at wrappedEntriesRef and accessRef, and returns its ref.
+func CloneDirectoryWithAccess(up Uploader, dirRef, accessRef, wrappedEntriesRef blob.Ref) (blob.Ref, error) {
+	ss := new(superset)
+	if err := ss.setFromBlobRef(up, dirRef); err != nil {
+		return blob.Ref{}, err
+	}
+	ss.Entries = wrappedEntriesRef
+	ss.Access = accessRef
+	j, err := json.Marshal(ss)
+	if err != nil {
+		return blob.Ref{}, err
+	}
+	newRef, err := up.Upload(j)
+	if err != nil {
+		return blob.Ref{}, fmt.Errorf("schema/act: uploading updated directory blob: %v", err)
+	}
+	return newRef, nil
+}
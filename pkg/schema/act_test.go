@@ -0,0 +1,179 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/third_party/code.google.com/p/go.crypto/curve25519"
+)
+
+// memUploader is a minimal in-memory Uploader, fetching and storing
+// blobs by their sha1 blobref.
+type memUploader struct {
+	blobs map[string][]byte
+}
+
+func newMemUploader() *memUploader {
+	return &memUploader{blobs: make(map[string][]byte)}
+}
+
+func (u *memUploader) Fetch(ref blob.Ref) (io.ReadCloser, int64, error) {
+	b, ok := u.blobs[ref.String()]
+	if !ok {
+		return nil, 0, fmt.Errorf("memUploader: no such blob %s", ref)
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+}
+
+func (u *memUploader) Upload(contents []byte) (blob.Ref, error) {
+	sum := sha1.Sum(contents)
+	ref, ok := blob.Parse(fmt.Sprintf("sha1-%x", sum))
+	if !ok {
+		return blob.Ref{}, fmt.Errorf("memUploader: couldn't construct blobref")
+	}
+	u.blobs[ref.String()] = contents
+	return ref, nil
+}
+
+// putDirWithEntries uploads a "static-set" blob with the given members
+// and a "directory" blob pointing at it, returning the directory's ref.
+func putDirWithEntries(t *testing.T, up *memUploader, members ...blob.Ref) blob.Ref {
+	t.Helper()
+	memberStrs := make([]string, len(members))
+	for i, m := range members {
+		memberStrs[i] = fmt.Sprintf("%q", m.String())
+	}
+	entriesJSON := fmt.Sprintf(`{"camliVersion":1,"camliType":"static-set","members":[%s]}`, joinStrs(memberStrs))
+	entriesRef, err := up.Upload([]byte(entriesJSON))
+	if err != nil {
+		t.Fatalf("uploading entries: %v", err)
+	}
+	dirJSON := fmt.Sprintf(`{"camliVersion":1,"camliType":"directory","entries":%q}`, entriesRef.String())
+	dirRef, err := up.Upload([]byte(dirJSON))
+	if err != nil {
+		t.Fatalf("uploading directory: %v", err)
+	}
+	return dirRef
+}
+
+func joinStrs(strs []string) string {
+	out := ""
+	for i, s := range strs {
+		if i > 0 {
+			out += ","
+		}
+		out += s
+	}
+	return out
+}
+
+func TestWrapDirectoryAccessPasswordRoundTrip(t *testing.T) {
+	up := newMemUploader()
+	member := mustUploadRef(t, up, "unused file blob")
+	dirRef := putDirWithEntries(t, up, member)
+
+	accessRef, wrappedEntriesRef, err := WrapDirectoryAccess(up, dirRef, "hunter2")
+	if err != nil {
+		t.Fatalf("WrapDirectoryAccess: %v", err)
+	}
+
+	as, err := fetchAccessSchema(up, accessRef)
+	if err != nil {
+		t.Fatalf("fetchAccessSchema: %v", err)
+	}
+
+	if _, err := as.sessionKey(&Credentials{Password: "wrong"}); err == nil {
+		t.Error("sessionKey with wrong password unexpectedly succeeded")
+	}
+
+	key, err := as.sessionKey(&Credentials{Password: "hunter2"})
+	if err != nil {
+		t.Fatalf("sessionKey with correct password: %v", err)
+	}
+	plain, err := decryptEntries(up, wrappedEntriesRef, key)
+	if err != nil {
+		t.Fatalf("decryptEntries: %v", err)
+	}
+	if !bytes.Contains(plain, []byte(member.String())) {
+		t.Errorf("decrypted entries %s don't mention member %s", plain, member)
+	}
+}
+
+func TestWrapDirectoryAccessSharedRoundTrip(t *testing.T) {
+	up := newMemUploader()
+	member := mustUploadRef(t, up, "unused file blob")
+	dirRef := putDirWithEntries(t, up, member)
+
+	var alicePriv, alicePub, bobPriv, bobPub [32]byte
+	if _, err := rand.Read(alicePriv[:]); err != nil {
+		t.Fatal(err)
+	}
+	curve25519.ScalarBaseMult(&alicePub, &alicePriv)
+	if _, err := rand.Read(bobPriv[:]); err != nil {
+		t.Fatal(err)
+	}
+	curve25519.ScalarBaseMult(&bobPub, &bobPriv)
+
+	accessRef, wrappedEntriesRef, err := WrapDirectoryAccessShared(up, dirRef, [][32]byte{alicePub, bobPub})
+	if err != nil {
+		t.Fatalf("WrapDirectoryAccessShared: %v", err)
+	}
+	as, err := fetchAccessSchema(up, accessRef)
+	if err != nil {
+		t.Fatalf("fetchAccessSchema: %v", err)
+	}
+
+	var mallory [32]byte
+	if _, err := rand.Read(mallory[:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.sessionKey(&Credentials{PrivateKey: &mallory}); err == nil {
+		t.Error("sessionKey with an unlisted private key unexpectedly succeeded")
+	}
+
+	for _, priv := range [][32]byte{alicePriv, bobPriv} {
+		priv := priv
+		key, err := as.sessionKey(&Credentials{PrivateKey: &priv})
+		if err != nil {
+			t.Fatalf("sessionKey: %v", err)
+		}
+		plain, err := decryptEntries(up, wrappedEntriesRef, key)
+		if err != nil {
+			t.Fatalf("decryptEntries: %v", err)
+		}
+		if !bytes.Contains(plain, []byte(member.String())) {
+			t.Errorf("decrypted entries %s don't mention member %s", plain, member)
+		}
+	}
+}
+
+func mustUploadRef(t *testing.T, up *memUploader, contents string) blob.Ref {
+	t.Helper()
+	ref, err := up.Upload([]byte(contents))
+	if err != nil {
+		t.Fatalf("uploading %q: %v", contents, err)
+	}
+	return ref
+}
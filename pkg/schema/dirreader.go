@@ -17,10 +17,12 @@ limitations under the License.
 package schema
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"camlistore.org/pkg/blob"
 )
@@ -30,14 +32,29 @@ import (
 type DirReader struct {
 	fetcher blob.SeekFetcher
 	ss      *superset
+	creds   *Credentials
+
+	// Concurrency bounds how many directory-entry schema blobs Readdir
+	// fetches in parallel when the fetcher doesn't support batch
+	// fetching. Zero means DefaultReaddirConcurrency.
+	Concurrency int
 
 	staticSet []blob.Ref
 	current   int
 }
 
 // NewDirReader creates a new directory reader and prepares to
-// fetch the static-set entries
+// fetch the static-set entries. If the directory is access-controlled
+// (see NewDirReaderWithCreds), StaticSet and Readdir return
+// ErrAccessDenied.
 func NewDirReader(fetcher blob.SeekFetcher, dirBlobRef blob.Ref) (*DirReader, error) {
+	return NewDirReaderWithCreds(fetcher, dirBlobRef, nil)
+}
+
+// NewDirReaderWithCreds is like NewDirReader, but additionally supplies
+// creds to unlock an ACT-protected directory's entries. creds may be
+// nil, equivalent to NewDirReader.
+func NewDirReaderWithCreds(fetcher blob.SeekFetcher, dirBlobRef blob.Ref, creds *Credentials) (*DirReader, error) {
 	ss := new(superset)
 	err := ss.setFromBlobRef(fetcher, dirBlobRef)
 	if err != nil {
@@ -50,6 +67,7 @@ func NewDirReader(fetcher blob.SeekFetcher, dirBlobRef blob.Ref) (*DirReader, er
 	if err != nil {
 		return nil, fmt.Errorf("schema/filereader: creating DirReader for %s: %v", dirBlobRef, err)
 	}
+	dr.creds = creds
 	dr.current = 0
 	return dr, nil
 }
@@ -81,7 +99,9 @@ func (ss *superset) setFromBlobRef(fetcher blob.SeekFetcher, blobRef blob.Ref) e
 	return nil
 }
 
-// StaticSet returns the whole of the static set members of that directory
+// StaticSet returns the whole of the static set members of that directory.
+// If the directory is access-controlled and dr wasn't given Credentials
+// that unlock it, StaticSet returns ErrAccessDenied.
 func (dr *DirReader) StaticSet() ([]blob.Ref, error) {
 	if dr.staticSet != nil {
 		return dr.staticSet, nil
@@ -90,13 +110,34 @@ func (dr *DirReader) StaticSet() ([]blob.Ref, error) {
 	if !staticSetBlobref.Valid() {
 		return nil, fmt.Errorf("schema/filereader: Invalid blobref\n")
 	}
-	rsc, _, err := dr.fetcher.Fetch(staticSetBlobref)
-	if err != nil {
-		return nil, fmt.Errorf("schema/filereader: fetching schema blob %s: %v", staticSetBlobref, err)
-	}
-	ss, err := parseSuperset(rsc)
-	if err != nil {
-		return nil, fmt.Errorf("schema/filereader: decoding schema blob %s: %v", staticSetBlobref, err)
+
+	var ss *superset
+	if dr.ss.Access.Valid() {
+		as, err := fetchAccessSchema(dr.fetcher, dr.ss.Access)
+		if err != nil {
+			return nil, err
+		}
+		key, err := as.sessionKey(dr.creds)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := decryptEntries(dr.fetcher, staticSetBlobref, key)
+		if err != nil {
+			return nil, err
+		}
+		ss = new(superset)
+		if err := json.Unmarshal(plain, ss); err != nil {
+			return nil, fmt.Errorf("schema/filereader: decoding decrypted schema blob %s: %v", staticSetBlobref, err)
+		}
+	} else {
+		rsc, _, err := dr.fetcher.Fetch(staticSetBlobref)
+		if err != nil {
+			return nil, fmt.Errorf("schema/filereader: fetching schema blob %s: %v", staticSetBlobref, err)
+		}
+		ss, err = parseSuperset(rsc)
+		if err != nil {
+			return nil, fmt.Errorf("schema/filereader: decoding schema blob %s: %v", staticSetBlobref, err)
+		}
 	}
 	if ss.Type != "static-set" {
 		return nil, fmt.Errorf("schema/filereader: expected \"static-set\" schema blob for %s, got %q", staticSetBlobref, ss.Type)
@@ -110,8 +151,21 @@ func (dr *DirReader) StaticSet() ([]blob.Ref, error) {
 	return dr.staticSet, nil
 }
 
+// DefaultReaddirConcurrency is the fan-out bound a DirReader uses when
+// its Concurrency field is zero, so a directory with thousands of
+// entries doesn't open thousands of simultaneous TLS connections
+// against a fetcher that can't batch.
+var DefaultReaddirConcurrency = 32
+
 // Readdir implements the Directory interface.
 func (dr *DirReader) Readdir(n int) (entries []DirectoryEntry, err error) {
+	return dr.ReaddirContext(context.Background(), n)
+}
+
+// ReaddirContext is like Readdir, but the listing can be canceled by
+// ctx; any blobs already fetched when ctx is done are discarded and
+// ctx.Err() is returned.
+func (dr *DirReader) ReaddirContext(ctx context.Context, n int) (entries []DirectoryEntry, err error) {
 	sts, err := dr.StaticSet()
 	if err != nil {
 		return nil, fmt.Errorf("schema/filereader: can't get StaticSet: %v\n", err)
@@ -126,38 +180,117 @@ func (dr *DirReader) Readdir(n int) (entries []DirectoryEntry, err error) {
 			up = len(sts)
 		}
 	}
+	refs := sts[dr.current:up]
+
+	if bf, ok := dr.fetcher.(blob.BatchFetcher); ok {
+		batchEntries, batchErr := dr.readdirBatch(bf, refs)
+		switch batchErr {
+		case nil:
+			return batchEntries, err
+		case blob.ErrBatchUnsupported:
+			// The fetcher advertises FetchBatch, but the server on
+			// the other end of it doesn't actually have the /camli/batch
+			// route (e.g. an older camlistored). Degrade to the
+			// per-blob fallback below instead of failing the listing.
+		default:
+			return nil, batchErr
+		}
+	}
+	entries, fanErr := dr.readdirFanOut(ctx, refs)
+	if fanErr != nil {
+		return nil, fanErr
+	}
+	return entries, err
+}
 
-	// TODO(bradfitz): push down information to the fetcher
-	// (e.g. cachingfetcher -> remote client http) that we're
-	// going to load a bunch, so the HTTP client (if not using
-	// SPDY) can do discovery and see if the server supports a
-	// batch handler, then get them all in one round-trip, rather
-	// than attacking the server with hundreds of parallel TLS
-	// setups.
+// readdirBatch loads refs in a single FetchBatch round-trip.
+func (dr *DirReader) readdirBatch(bf blob.BatchFetcher, refs []blob.Ref) ([]DirectoryEntry, error) {
+	results, err := bf.FetchBatch(refs)
+	if err == blob.ErrBatchUnsupported {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("schema/filereader: batch fetch: %v", err)
+	}
+	entries := make([]DirectoryEntry, 0, len(results))
+	for _, res := range results {
+		if res.Err != nil {
+			return nil, fmt.Errorf("schema/filereader: can't fetch dirEntry %s: %v", res.Ref, res.Err)
+		}
+		// Build the entry from the contents we already have in hand;
+		// re-fetching res.Ref individually here would turn the "one
+		// round-trip" batch path into a batch request plus N serial
+		// fetches, on top of leaking res.Contents unread.
+		entry, err := NewDirectoryEntryFromReader(dr.fetcher, res.Ref, res.Contents)
+		res.Contents.Close()
+		if err != nil {
+			return nil, fmt.Errorf("schema/filereader: can't create dirEntry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
 
+// readdirFanOut loads refs with a bounded pool of goroutines, the
+// fallback for fetchers that don't implement blob.BatchFetcher.
+func (dr *DirReader) readdirFanOut(ctx context.Context, refs []blob.Ref) ([]DirectoryEntry, error) {
 	type res struct {
-		ent DirectoryEntry
-		err error
-	}
-	var cs []chan res
-
-	// Kick off all directory entry loads.
-	// TODO: bound this?
-	for _, entRef := range sts[dr.current:up] {
-		c := make(chan res, 1)
-		cs = append(cs, c)
-		go func(entRef blob.Ref) {
-			entry, err := NewDirectoryEntryFromBlobRef(dr.fetcher, entRef)
-			c <- res{entry, err}
-		}(entRef)
-	}
-
-	for _, c := range cs {
-		res := <-c
-		if res.err != nil {
-			return nil, fmt.Errorf("schema/filereader: can't create dirEntry: %v\n", err)
+		i     int
+		entry DirectoryEntry
+		err   error
+	}
+	results := make([]res, len(refs))
+	work := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+
+	var wg sync.WaitGroup
+	nWorkers := dr.Concurrency
+	if nWorkers <= 0 {
+		nWorkers = DefaultReaddirConcurrency
+	}
+	if nWorkers > len(refs) {
+		nWorkers = len(refs)
+	}
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case i, ok := <-work:
+					if !ok {
+						return
+					}
+					entry, err := NewDirectoryEntryFromBlobRef(dr.fetcher, refs[i])
+					results[i] = res{i, entry, err}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(work)
+		for i := range refs {
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries := make([]DirectoryEntry, len(refs))
+	for _, r := range results {
+		if r.err != nil {
+			return nil, fmt.Errorf("schema/filereader: can't create dirEntry: %v\n", r.err)
 		}
-		entries = append(entries, res.ent)
+		entries[r.i] = r.entry
 	}
 	return entries, nil
 }